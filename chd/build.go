@@ -0,0 +1,33 @@
+package chd
+
+import (
+	"bytes"
+
+	"github.com/alecthomas/mph"
+)
+
+// buildCHD runs mph's CHD construction over the (key, value) pairs added
+// to b, then re-parses the result through this package's own Mmap. The
+// parent package's CHDBuilder.Build returns its own *mph.CHD, which
+// serializes in the plain legacy layout Mmap has always understood (see
+// mmapV1) but knows nothing of this package's Hasher selection or v2
+// format; round-tripping through Mmap here is what turns that into a
+// *CHD callers can Write back out in the v2, checksummed, hasher-aware
+// form.
+//
+// Because CHDBuilder.Build always hashes with mph's own internal
+// function and mmapV1 always assumes fnvHasher for the legacy bytes it
+// produces, every *CHD built this way records fnvHasher in its v2
+// header -- see BuildOptions for the resulting hasher-selection
+// limitation.
+func buildCHD(b *mph.CHDBuilder) (*CHD, error) {
+	built, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := built.Write(&buf); err != nil {
+		return nil, err
+	}
+	return Mmap(buf.Bytes())
+}
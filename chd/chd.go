@@ -9,24 +9,36 @@ package chd
 import (
 	"bytes"
 	"encoding/binary"
-	"github.com/alecthomas/mph"
-	"github.com/alecthomas/unsafeslice"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
+
+	"github.com/alecthomas/unsafeslice"
 )
 
 // CHD hash table lookup.
 type CHD struct {
+	// Hasher used to compute bucket and slot indices; must match the one
+	// the table was built with.
+	hasher Hasher
 	// Random hash function table.
 	r []uint64
 	// Array of indices into hash function table r
 	indices []uint16
-	// Final table of values.
+	// Number of entries; authoritative even when keys is empty.
+	entryCount uint32
+	// omitKeys is true when keys was intentionally left empty at build
+	// time (BuildOptions.OmitKeys), so Get can't verify and returns the
+	// same unverified value as Lookup.
+	omitKeys bool
+	// Final table of values, and the keys they were built from (empty in
+	// OmitKeys mode).
 	keys   [][]byte
 	values [][]byte
 }
 
-// Read a serialized CHD.
+// Read a serialized CHD by copying it into memory in full. For large
+// tables, prefer Open, which maps the file instead of reading it.
 func Read(r io.Reader) (*CHD, error) {
 	b, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -49,9 +61,24 @@ func (b *byteSliceIterator) ReadInt() uint64 {
 	return uint64(binary.LittleEndian.Uint32(b.Read(4)))
 }
 
-// Alias the CHD structure over an existing byte region (typically mmapped).
+// Alias the CHD structure over an existing byte region (typically
+// mmapped). Mmap accepts both the versioned, checksummed v2 format that
+// Write now emits and the legacy v1 format tables serialized by code
+// that predates this package's Hasher/v2 support used, with no header at
+// all.
 func Mmap(b []byte) (*CHD, error) {
-	c := &CHD{}
+	if len(b) >= 4 && string(b[:4]) == chdMagic {
+		return mmapV2(b)
+	}
+	return mmapV1(b)
+}
+
+// mmapV1 parses the legacy (pre-v2) format: length-prefixed r/indices/
+// entry tables with no header, hasher id, checksum or alignment
+// guarantees. Since no hasher id is present, it always uses fnvHasher,
+// the only Hasher that existed when this format was the only one.
+func mmapV1(b []byte) (*CHD, error) {
+	c := &CHD{hasher: fnvHasher{}}
 
 	bi := &byteSliceIterator{b: b}
 
@@ -63,6 +90,7 @@ func Mmap(b []byte) (*CHD, error) {
 	c.indices = unsafeslice.Uint16SliceFromByteSlice(bi.Read(il * 2))
 
 	el := bi.ReadInt()
+	c.entryCount = uint32(el)
 
 	c.keys = make([][]byte, el)
 	c.values = make([][]byte, el)
@@ -77,74 +105,188 @@ func Mmap(b []byte) (*CHD, error) {
 	return c, nil
 }
 
-// Get an entry from the hash table.
+// mmapV2 parses the versioned, checksummed format: a 32-byte header
+// followed by the 8-byte-aligned r and indices tables and then the
+// length-prefixed entry table.
+func mmapV2(b []byte) (*CHD, error) {
+	h, err := parseHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyChecksum(b, h); err != nil {
+		return nil, err
+	}
+	hasher, err := hasherByID(h.hasher)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CHD{hasher: hasher, entryCount: h.entryCount}
+
+	payload := b[headerSize:]
+	rBytes := uint64(h.rLen) * 8
+	c.r = unsafeslice.Uint64SliceFromByteSlice(payload[:rBytes])
+
+	indicesBytes := uint64(h.indicesLen) * 2
+	c.indices = unsafeslice.Uint16SliceFromByteSlice(payload[rBytes : rBytes+indicesBytes])
+
+	bi := &byteSliceIterator{b: payload[rBytes+indicesBytes:]}
+	c.values = make([][]byte, h.entryCount)
+	if h.flags&headerFlagOmitKeys != 0 {
+		c.omitKeys = true
+		for i := range c.values {
+			vl := bi.ReadInt()
+			c.values[i] = bi.Read(vl)
+		}
+	} else {
+		c.keys = make([][]byte, h.entryCount)
+		for i := range c.keys {
+			kl := bi.ReadInt()
+			vl := bi.ReadInt()
+			c.keys[i] = bi.Read(kl)
+			c.values[i] = bi.Read(vl)
+		}
+	}
+
+	return c, nil
+}
+
+// Get an entry from the hash table, verifying that key was actually in
+// the set the table was built from. This is the default, safe lookup
+// path. It requires keys to have been kept at build time; on a table
+// built with BuildOptions{OmitKeys: true} there is nothing to verify
+// against, so Get degrades to the same unverified answer as Lookup. Use
+// Get when you cannot guarantee queries are limited to in-set keys.
 func (c *CHD) Get(key []byte) []byte {
+	ti := c.indexOf(key)
+	if ti < 0 {
+		return nil
+	}
+	if !c.omitKeys && bytes.Compare(c.keys[ti], key) != 0 {
+		return nil
+	}
+	return c.values[ti]
+}
+
+// Lookup returns a key's table slot and value without verifying that key
+// was actually in the original set. In OmitKeys mode no keys are stored
+// to verify against, so Get returns the same unverified value and
+// Lookup adds the slot index: the result is only meaningful when key was among the keys the table was
+// built from, and is a "false positive" of some other in-set key
+// otherwise -- the usual caveat for a pure MPHF. Prefer Get unless the
+// table was built with BuildOptions{OmitKeys: true}.
+func (c *CHD) Lookup(key []byte) (index uint32, value []byte) {
+	ti := c.indexOf(key)
+	if ti < 0 {
+		return 0, nil
+	}
+	return uint32(ti), c.values[ti]
+}
+
+// indexOf returns key's final table slot, or -1 if its bucket fell in an
+// unassigned slot of the hash table.
+func (c *CHD) indexOf(key []byte) int64 {
 	r0 := c.r[0]
-	h := chdHash(key) ^ r0
+	h := c.hasher.Sum64(r0, key)
 	i := h % uint64(len(c.indices))
 	ri := c.indices[i]
 	// This can happen if there were unassigned slots in the hash table.
 	if ri >= uint16(len(c.r)) {
-		return nil
+		return -1
 	}
 	r := c.r[ri]
-	ti := (h ^ r) % uint64(len(c.keys))
-	// fmt.Printf("r[0]=%d, h=%d, i=%d, ri=%d, r=%d, ti=%d\n", c.r[0], h, i, ri, r, ti)
-	k := c.keys[ti]
-	if bytes.Compare(k, key) != 0 {
-		return nil
-	}
-	v := c.values[ti]
-	return v
+	return int64((h ^ r) % uint64(c.entryCount))
 }
 
 func (c *CHD) Len() int {
-	return len(c.keys)
+	return int(c.entryCount)
+}
+
+// Entry is a single (key, value) pair yielded by an Iterator.
+type Entry struct {
+	Key   []byte
+	Value []byte
 }
 
-// Iterate over entries in the hash table.
-func (c *CHD) Iterate() mph.Iterator {
+// Iterator walks the entries of a CHD in table order. It is this
+// package's own type rather than anything from the parent mph package,
+// whose Iterator is a concrete struct and whose Entry doesn't exist as an
+// exported type at all.
+type Iterator interface {
+	Get() Entry
+	Next() Iterator
+}
+
+// Iterate over entries in the hash table. It returns nil for a table
+// built with BuildOptions{OmitKeys: true}, since there are no keys to
+// iterate.
+func (c *CHD) Iterate() Iterator {
 	if len(c.keys) == 0 {
 		return nil
 	}
 	return &chdIterator{c: c}
 }
 
-// Serialize the CHD. The serialized form is conducive to mmapped access. See
-// the Mmap function for details.
+// Write serializes the CHD in the versioned, checksummed v2 format: a
+// 32-byte header (magic, version, hasher id, segment lengths and a
+// CRC32C of the payload) followed by the 8-byte-aligned r and indices
+// tables and then the length-prefixed entry table. See Mmap for the
+// corresponding reader, which also accepts the legacy pre-v2 format.
 func (c *CHD) Write(w io.Writer) error {
+	hasher := c.hasher
+	if hasher == nil {
+		hasher = fnvHasher{}
+	}
+
+	var payload bytes.Buffer
 	write := func(nd ...interface{}) error {
 		for _, d := range nd {
-			if err := binary.Write(w, binary.LittleEndian, d); err != nil {
+			if err := binary.Write(&payload, binary.LittleEndian, d); err != nil {
 				return err
 			}
 		}
 		return nil
 	}
 
-	data := []interface{}{
-		uint32(len(c.r)), c.r,
-		uint32(len(c.indices)), c.indices,
-		uint32(len(c.keys)),
-	}
-
-	if err := write(data...); err != nil {
+	if err := write(c.r, c.indices); err != nil {
 		return err
 	}
 
-	for i := range c.keys {
-		k, v := c.keys[i], c.values[i]
-		if err := write(uint32(len(k)), uint32(len(v))); err != nil {
-			return err
-		}
-		if _, err := w.Write(k); err != nil {
-			return err
-		}
-		if _, err := w.Write(v); err != nil {
-			return err
+	for i, v := range c.values {
+		if c.omitKeys {
+			if err := write(uint32(len(v))); err != nil {
+				return err
+			}
+		} else {
+			k := c.keys[i]
+			if err := write(uint32(len(k)), uint32(len(v))); err != nil {
+				return err
+			}
+			payload.Write(k)
 		}
+		payload.Write(v)
+	}
+
+	flags := uint8(0)
+	if c.omitKeys {
+		flags |= headerFlagOmitKeys
+	}
+	hdr := make([]byte, headerSize)
+	writeHeader(hdr, header{
+		version:    chdVersion,
+		flags:      flags,
+		hasher:     idForHasher(hasher),
+		entryCount: c.entryCount,
+		rLen:       uint32(len(c.r)),
+		indicesLen: uint32(len(c.indices)),
+		checksum:   crc32.Checksum(payload.Bytes(), crc32cTable),
+	})
+
+	if _, err := w.Write(hdr); err != nil {
+		return err
 	}
-	return nil
+	_, err := w.Write(payload.Bytes())
+	return err
 }
 
 type chdIterator struct {
@@ -152,11 +294,11 @@ type chdIterator struct {
 	c *CHD
 }
 
-func (c *chdIterator) Get() mph.Entry {
-	return &chdEntry{key: c.c.keys[c.i], value: c.c.values[c.i]}
+func (c *chdIterator) Get() Entry {
+	return Entry{Key: c.c.keys[c.i], Value: c.c.values[c.i]}
 }
 
-func (c *chdIterator) Next() mph.Iterator {
+func (c *chdIterator) Next() Iterator {
 	c.i++
 	if c.i >= len(c.c.keys) {
 		return nil
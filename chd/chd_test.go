@@ -0,0 +1,156 @@
+package chd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func newTestCHD(omitKeys bool) *CHD {
+	c := &CHD{
+		hasher:     fnvHasher{},
+		r:          []uint64{1, 2, 3},
+		indices:    []uint16{0, 1, 2, 0xffff},
+		entryCount: 2,
+		omitKeys:   omitKeys,
+		values:     [][]byte{[]byte("v0"), []byte("v1")},
+	}
+	if !omitKeys {
+		c.keys = [][]byte{[]byte("k0"), []byte("k1")}
+	}
+	return c
+}
+
+func TestWriteMmapRoundTrip(t *testing.T) {
+	c := newTestCHD(false)
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := Verify(buf.Bytes()); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	got, err := Mmap(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Mmap: %v", err)
+	}
+	if got.Len() != c.Len() {
+		t.Fatalf("Len = %d, want %d", got.Len(), c.Len())
+	}
+	for i, k := range c.keys {
+		if !bytes.Equal(got.keys[i], k) {
+			t.Fatalf("keys[%d] = %q, want %q", i, got.keys[i], k)
+		}
+		if !bytes.Equal(got.values[i], c.values[i]) {
+			t.Fatalf("values[%d] = %q, want %q", i, got.values[i], c.values[i])
+		}
+	}
+}
+
+func TestWriteMmapRoundTripOmitKeys(t *testing.T) {
+	c := newTestCHD(true)
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, err := Mmap(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Mmap: %v", err)
+	}
+	if !got.omitKeys {
+		t.Fatal("omitKeys flag lost on round trip")
+	}
+	if len(got.keys) != 0 {
+		t.Fatalf("keys = %v, want none", got.keys)
+	}
+	for i, v := range c.values {
+		if !bytes.Equal(got.values[i], v) {
+			t.Fatalf("values[%d] = %q, want %q", i, got.values[i], v)
+		}
+	}
+}
+
+func TestWriteMmapRoundTripHasherID(t *testing.T) {
+	for _, h := range []Hasher{fnvHasher{}, maphashHasher{}, xxhashHasher{}} {
+		c := newTestCHD(false)
+		c.hasher = h
+		var buf bytes.Buffer
+		if err := c.Write(&buf); err != nil {
+			t.Fatalf("Write(%T): %v", h, err)
+		}
+		got, err := Mmap(buf.Bytes())
+		if err != nil {
+			t.Fatalf("Mmap(%T): %v", h, err)
+		}
+		if reflect.TypeOf(got.hasher) != reflect.TypeOf(h) {
+			t.Fatalf("hasher = %T, want %T", got.hasher, h)
+		}
+	}
+}
+
+func TestVerifyChecksumMismatch(t *testing.T) {
+	c := newTestCHD(false)
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	b := buf.Bytes()
+	b[len(b)-1] ^= 0xff // corrupt the last payload byte
+	if err := Verify(b); err != ErrChecksum {
+		t.Fatalf("Verify = %v, want ErrChecksum", err)
+	}
+}
+
+// TestMmapLegacyFormat builds a true legacy (pre-v2) file by hand --
+// length-prefixed r/indices/entry tables with no header, hasher id or
+// checksum at all -- and checks Mmap/Verify parse it without shifting
+// any offsets (the bug chunk0-3 fixed).
+func TestMmapLegacyFormat(t *testing.T) {
+	var buf bytes.Buffer
+	putUint32 := func(v uint32) {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], v)
+		buf.Write(b[:])
+	}
+	putUint64 := func(v uint64) {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], v)
+		buf.Write(b[:])
+	}
+	putUint16 := func(v uint16) {
+		var b [2]byte
+		binary.LittleEndian.PutUint16(b[:], v)
+		buf.Write(b[:])
+	}
+
+	r := []uint64{5, 6}
+	putUint32(uint32(len(r)))
+	for _, v := range r {
+		putUint64(v)
+	}
+	indices := []uint16{0, 1}
+	putUint32(uint32(len(indices)))
+	for _, v := range indices {
+		putUint16(v)
+	}
+	putUint32(1) // entry count
+	putUint32(2) // key length
+	putUint32(2) // value length
+	buf.WriteString("k0")
+	buf.WriteString("v0")
+
+	if err := Verify(buf.Bytes()); err != nil {
+		t.Fatalf("Verify legacy: %v", err)
+	}
+	c, err := Mmap(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Mmap legacy: %v", err)
+	}
+	if c.Len() != 1 || !bytes.Equal(c.keys[0], []byte("k0")) || !bytes.Equal(c.values[0], []byte("v0")) {
+		t.Fatalf("unexpected legacy parse result: keys=%q values=%q", c.keys, c.values)
+	}
+	if _, ok := c.hasher.(fnvHasher); !ok {
+		t.Fatalf("legacy hasher = %T, want fnvHasher", c.hasher)
+	}
+}
@@ -0,0 +1,308 @@
+package chd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/alecthomas/mph"
+)
+
+// dbMagic identifies a DBWriter/DBReader file, distinct from a bare
+// CHD.Write serialization.
+const dbMagic = "MPDB"
+
+// dbHeader is the fixed-size header written at the start of a database
+// file produced by DBWriter.Finalize.
+type dbHeader struct {
+	count      uint64
+	flags      uint32
+	chdOffset  uint64
+	chdLength  uint64
+	blobOffset uint64
+	blobLength uint64
+}
+
+const (
+	dbFlagIntValues uint32 = 1 << 0
+)
+
+const dbHeaderSize = 4 + 4 + 8 + 8 + 8 + 8 + 8 // magic + flags + count + 4 uint64 offsets/lengths
+
+// refSize is the width of the (offset, length) pair stored as the CHD
+// value for each key; the real value bytes live in the blob segment.
+const refSize = 8 + 4
+
+// DBWriter builds a constant key/value database indexed by a minimal
+// perfect hash, without requiring the caller to hold every (key, value)
+// pair in memory at once. Values are streamed straight to a temporary
+// blob file as they are added; only keys and a small (offset, length)
+// reference per key are kept in memory while the CHD is constructed.
+// Call Finalize to run the CHD build and emit the finished database file.
+type DBWriter struct {
+	path     string
+	blob     *os.File
+	blobBuf  *bufio.Writer
+	blobSize uint64
+	intMode  bool
+	opts     BuildOptions
+	builder  *mph.CHDBuilder
+}
+
+// NewDBWriter creates a DBWriter that will write its finished database to
+// path when Finalize is called.
+func NewDBWriter(path string) (*DBWriter, error) {
+	return NewDBWriterOptions(path, BuildOptions{})
+}
+
+// NewDBWriterOptions is like NewDBWriter but takes BuildOptions. With
+// OmitKeys set, the underlying CHD's keys segment is left out of the
+// finished file, shrinking it at the cost of Get degrading to Lookup's
+// unverified, false-positive-possible semantics -- see CHD.Lookup.
+func NewDBWriterOptions(path string, opts BuildOptions) (*DBWriter, error) {
+	return newDBWriter(path, opts, false)
+}
+
+// NewIntDBWriter is like NewDBWriter, but values must be added with
+// PutUint64 and read with DBReader.GetUint64. Each uint64 is written as
+// 8 bytes to the blob segment and referenced by the same (offset, length)
+// pair that Put uses; values are not stored inline in the CHD, so the
+// file is no smaller than one built with Put and 8-byte values.
+func NewIntDBWriter(path string) (*DBWriter, error) {
+	return NewIntDBWriterOptions(path, BuildOptions{})
+}
+
+// NewIntDBWriterOptions combines NewIntDBWriter and NewDBWriterOptions.
+func NewIntDBWriterOptions(path string, opts BuildOptions) (*DBWriter, error) {
+	return newDBWriter(path, opts, true)
+}
+
+func newDBWriter(path string, opts BuildOptions, intMode bool) (*DBWriter, error) {
+	blob, err := ioutil.TempFile("", "mph-db-blob-")
+	if err != nil {
+		return nil, err
+	}
+	return &DBWriter{
+		path:    path,
+		blob:    blob,
+		blobBuf: bufio.NewWriter(blob),
+		intMode: intMode,
+		opts:    opts,
+		builder: mph.Builder(),
+	}, nil
+}
+
+// Put streams a (key, value) pair into the database. Key must be unique
+// among all Put/PutUint64 calls on this writer.
+func (w *DBWriter) Put(key, value []byte) error {
+	if w.intMode {
+		return fmt.Errorf("chd: Put called on an integer-value DBWriter, use PutUint64")
+	}
+	ref, err := w.appendBlob(value)
+	if err != nil {
+		return err
+	}
+	w.builder.Add(key, ref)
+	return nil
+}
+
+// PutUint64 streams a (key, value) pair whose value is a fixed-width
+// uint64. It may only be used on a DBWriter created with NewIntDBWriter.
+func (w *DBWriter) PutUint64(key []byte, value uint64) error {
+	if !w.intMode {
+		return fmt.Errorf("chd: PutUint64 called on a DBWriter not created with NewIntDBWriter")
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], value)
+	ref, err := w.appendBlob(buf[:])
+	if err != nil {
+		return err
+	}
+	w.builder.Add(key, ref)
+	return nil
+}
+
+// appendBlob appends value to the blob file and returns the (offset,
+// length) reference to hand to the CHD builder in place of the value
+// itself.
+func (w *DBWriter) appendBlob(value []byte) ([]byte, error) {
+	ref := make([]byte, refSize)
+	binary.LittleEndian.PutUint64(ref[0:8], w.blobSize)
+	binary.LittleEndian.PutUint32(ref[8:12], uint32(len(value)))
+	if _, err := w.blobBuf.Write(value); err != nil {
+		return nil, err
+	}
+	w.blobSize += uint64(len(value))
+	return ref, nil
+}
+
+// Finalize runs CHD construction over the accumulated keys and writes the
+// finished database to the path given to NewDBWriter/NewIntDBWriter. The
+// DBWriter must not be used again afterwards.
+func (w *DBWriter) Finalize() (err error) {
+	if ferr := w.blobBuf.Flush(); ferr != nil {
+		return ferr
+	}
+	built, err := buildCHD(w.builder)
+	if err != nil {
+		return err
+	}
+	if w.opts.OmitKeys {
+		built.keys = nil
+		built.omitKeys = true
+	}
+
+	out, err := os.Create(w.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	var chdBuf bytes.Buffer
+	if err := built.Write(&chdBuf); err != nil {
+		return err
+	}
+
+	flags := uint32(0)
+	if w.intMode {
+		flags |= dbFlagIntValues
+	}
+	hdr := dbHeader{
+		count:      uint64(built.Len()),
+		flags:      flags,
+		chdOffset:  dbHeaderSize,
+		chdLength:  uint64(chdBuf.Len()),
+		blobOffset: dbHeaderSize + uint64(chdBuf.Len()),
+		blobLength: w.blobSize,
+	}
+	if err := writeDBHeader(out, hdr); err != nil {
+		return err
+	}
+	if _, err := out.Write(chdBuf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.blob.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, w.blob); err != nil {
+		return err
+	}
+
+	w.blob.Close()
+	os.Remove(w.blob.Name())
+	return nil
+}
+
+func writeDBHeader(w io.Writer, h dbHeader) error {
+	if _, err := w.Write([]byte(dbMagic)); err != nil {
+		return err
+	}
+	for _, d := range []interface{}{h.flags, h.count, h.chdOffset, h.chdLength, h.blobOffset, h.blobLength} {
+		if err := binary.Write(w, binary.LittleEndian, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DBReader answers lookups against a database built by DBWriter. It is
+// backed by a single mmapped file, so Get costs one hash, one bounds
+// check and one blob read.
+type DBReader struct {
+	chd     *CHD
+	blob    []byte
+	intMode bool
+	closer  io.Closer
+}
+
+// OpenDB opens a database file produced by DBWriter.Finalize via the
+// mmap-backed loader used by Open.
+func OpenDB(path string) (*DBReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return openDB(f)
+}
+
+func openDB(f *os.File) (*DBReader, error) {
+	b, closer, err := mapFile(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < dbHeaderSize || string(b[:4]) != dbMagic {
+		closer.Close()
+		return nil, fmt.Errorf("chd: not a DBWriter database file")
+	}
+	flags := binary.LittleEndian.Uint32(b[4:8])
+	chdOffset := binary.LittleEndian.Uint64(b[16:24])
+	chdLength := binary.LittleEndian.Uint64(b[24:32])
+	blobOffset := binary.LittleEndian.Uint64(b[32:40])
+	blobLength := binary.LittleEndian.Uint64(b[40:48])
+
+	size := uint64(len(b))
+	if chdOffset > size || chdLength > size-chdOffset ||
+		blobOffset > size || blobLength > size-blobOffset {
+		closer.Close()
+		return nil, fmt.Errorf("chd: truncated or corrupt database file")
+	}
+
+	c, err := Mmap(b[chdOffset : chdOffset+chdLength])
+	if err != nil {
+		closer.Close()
+		return nil, err
+	}
+	return &DBReader{
+		chd:     c,
+		blob:    b[blobOffset : blobOffset+blobLength],
+		intMode: flags&dbFlagIntValues != 0,
+		closer:  closer,
+	}, nil
+}
+
+// Get looks up key and returns its value and whether it was found.
+func (r *DBReader) Get(key []byte) ([]byte, bool) {
+	if r.intMode {
+		return nil, false
+	}
+	ref := r.chd.Get(key)
+	if ref == nil {
+		return nil, false
+	}
+	off := binary.LittleEndian.Uint64(ref[0:8])
+	l := binary.LittleEndian.Uint32(ref[8:12])
+	return r.blob[off : off+uint64(l)], true
+}
+
+// GetUint64 looks up key in a database built by NewIntDBWriter and
+// returns its value and whether it was found.
+func (r *DBReader) GetUint64(key []byte) (uint64, bool) {
+	if !r.intMode {
+		return 0, false
+	}
+	ref := r.chd.Get(key)
+	if ref == nil {
+		return 0, false
+	}
+	off := binary.LittleEndian.Uint64(ref[0:8])
+	return binary.LittleEndian.Uint64(r.blob[off : off+8]), true
+}
+
+// Len returns the number of entries in the database.
+func (r *DBReader) Len() int {
+	return r.chd.Len()
+}
+
+// Close releases the underlying mapping.
+func (r *DBReader) Close() error {
+	return r.closer.Close()
+}
@@ -0,0 +1,114 @@
+package chd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDBWriterReaderRoundTrip(t *testing.T) {
+	f, err := os.CreateTemp("", "mph-db-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := f.Name()
+	f.Close()
+	defer os.Remove(path)
+
+	w, err := NewDBWriter(path)
+	if err != nil {
+		t.Fatalf("NewDBWriter: %v", err)
+	}
+	want := map[string]string{"a": "1", "b": "2", "c": "3"}
+	for k, v := range want {
+		if err := w.Put([]byte(k), []byte(v)); err != nil {
+			t.Fatalf("Put(%q): %v", k, err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	r, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	defer r.Close()
+
+	if r.Len() != len(want) {
+		t.Fatalf("Len = %d, want %d", r.Len(), len(want))
+	}
+	for k, v := range want {
+		got, ok := r.Get([]byte(k))
+		if !ok || string(got) != v {
+			t.Fatalf("Get(%q) = %q, %v; want %q, true", k, got, ok, v)
+		}
+	}
+	if _, ok := r.Get([]byte("missing")); ok {
+		t.Fatal("Get(missing) = true, want false")
+	}
+}
+
+func TestIntDBWriterReaderRoundTrip(t *testing.T) {
+	f, err := os.CreateTemp("", "mph-intdb-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := f.Name()
+	f.Close()
+	defer os.Remove(path)
+
+	w, err := NewIntDBWriter(path)
+	if err != nil {
+		t.Fatalf("NewIntDBWriter: %v", err)
+	}
+	want := map[string]uint64{"a": 1, "b": 2, "c": 3}
+	for k, v := range want {
+		if err := w.PutUint64([]byte(k), v); err != nil {
+			t.Fatalf("PutUint64(%q): %v", k, err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	r, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	defer r.Close()
+
+	for k, v := range want {
+		got, ok := r.GetUint64([]byte(k))
+		if !ok || got != v {
+			t.Fatalf("GetUint64(%q) = %d, %v; want %d, true", k, got, ok, v)
+		}
+	}
+}
+
+// TestOpenDBTruncatedHeader regression-tests the chunk0-2 fix: a header
+// whose declared chdLength reaches past the end of the mapped file must
+// be rejected, not sliced into a panic.
+func TestOpenDBTruncatedHeader(t *testing.T) {
+	f, err := os.CreateTemp("", "mph-db-truncated-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := f.Name()
+	defer os.Remove(path)
+
+	hdr := dbHeader{
+		count:      1,
+		chdOffset:  dbHeaderSize,
+		chdLength:  1 << 40,
+		blobOffset: dbHeaderSize,
+		blobLength: 0,
+	}
+	if err := writeDBHeader(f, hdr); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if _, err := OpenDB(path); err == nil {
+		t.Fatal("OpenDB on truncated file = nil error, want error")
+	}
+}
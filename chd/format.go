@@ -0,0 +1,147 @@
+package chd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// chdMagic identifies the v2 serialized format. Earlier CHDs have no
+// magic, version or hasher id at all -- they start directly with the
+// length-prefixed r-table -- which is how Mmap tells the two formats
+// apart.
+const chdMagic = "CHD1"
+
+// chdVersion is the only version of the v2 format; it exists so future
+// incompatible changes have somewhere to signal themselves.
+const chdVersion = 2
+
+// headerSize is the fixed size of the v2 header. It is a multiple of 8 so
+// that the r-table, which immediately follows it, starts 8-byte aligned;
+// since every r entry is itself 8 bytes, the indices table that follows r
+// is then aligned too.
+const headerSize = 32
+
+var (
+	// ErrBadMagic is returned when a byte region claims to be v2 but
+	// doesn't start with chdMagic, or is too short to hold a header.
+	ErrBadMagic = errors.New("chd: bad magic")
+	// ErrVersion is returned when a v2 header declares a format version
+	// this build of the package doesn't understand.
+	ErrVersion = errors.New("chd: unsupported format version")
+	// ErrChecksum is returned when a v2 payload's checksum doesn't match
+	// the one recorded in its header.
+	ErrChecksum = errors.New("chd: checksum mismatch")
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// BuildOptions configures how a CHD is built.
+//
+// KNOWN LIMITATION: Hasher selection is read-only. Every construction
+// path in this package (buildCHD, used by both DBWriter.Finalize and
+// ShardedBuilder.Build) delegates to mph.CHDBuilder.Build, which cannot
+// be modified and always hashes with its own internal, hardcoded
+// function; the result is then re-parsed by mmapV1, which accordingly
+// always assumes fnvHasher. So although Mmap/Get correctly dispatch on
+// whichever Hasher a v2 header names, there is currently no public way
+// to ever build a table that records anything other than fnvHasher in
+// that header -- maphashHasher and xxhashHasher can only be reached by
+// constructing a *CHD by hand and calling Write directly. BuildOptions
+// has no Hasher field because there is nothing for it to do yet; adding
+// one needs either a builder of this package's own or changes upstream
+// in mph.CHDBuilder.
+type BuildOptions struct {
+	// OmitKeys builds a "pure MPHF": the keys segment is left out of the
+	// serialized file entirely, roughly halving its size. Lookups on
+	// such a table, through Lookup or Get alike, are unverified and only
+	// meaningful for keys that were in the original build set -- see
+	// Lookup for details.
+	OmitKeys bool
+}
+
+// headerFlagOmitKeys marks a table built with BuildOptions{OmitKeys:
+// true}: the entry table carries only values, so lookups cannot be
+// verified and Get gives the same unverified answer as Lookup.
+const headerFlagOmitKeys uint8 = 1 << 0
+
+// header is the parsed form of the 32-byte v2 header.
+type header struct {
+	version    uint8
+	flags      uint8
+	hasher     hasherID
+	entryCount uint32
+	rLen       uint32
+	indicesLen uint32
+	checksum   uint32
+}
+
+// parseHeader reads and validates the fixed fields of a v2 header. It does
+// not check the checksum; callers that need integrity verification should
+// call verifyChecksum or Verify.
+func parseHeader(b []byte) (header, error) {
+	if len(b) < headerSize || string(b[:4]) != chdMagic {
+		return header{}, ErrBadMagic
+	}
+	h := header{
+		version:    b[4],
+		flags:      b[5],
+		hasher:     hasherID(b[6]),
+		entryCount: binary.LittleEndian.Uint32(b[8:12]),
+		rLen:       binary.LittleEndian.Uint32(b[12:16]),
+		indicesLen: binary.LittleEndian.Uint32(b[16:20]),
+		checksum:   binary.LittleEndian.Uint32(b[20:24]),
+	}
+	if h.version != chdVersion {
+		return header{}, ErrVersion
+	}
+	if uint64(len(b))-headerSize < uint64(h.rLen)*8+uint64(h.indicesLen)*2 {
+		return header{}, fmt.Errorf("chd: truncated file")
+	}
+	return h, nil
+}
+
+// writeHeader serialises h as the 32-byte v2 header.
+func writeHeader(w []byte, h header) {
+	copy(w, chdMagic)
+	w[4] = h.version
+	w[5] = h.flags
+	w[6] = byte(h.hasher)
+	w[7] = 0 // reserved
+	binary.LittleEndian.PutUint32(w[8:12], h.entryCount)
+	binary.LittleEndian.PutUint32(w[12:16], h.rLen)
+	binary.LittleEndian.PutUint32(w[16:20], h.indicesLen)
+	binary.LittleEndian.PutUint32(w[20:24], h.checksum)
+	// w[24:32] reserved, left zero.
+}
+
+// verifyChecksum checks the CRC32C recorded in h against the payload that
+// follows the header in b.
+func verifyChecksum(b []byte, h header) error {
+	payload := b[headerSize:]
+	if crc32.Checksum(payload, crc32cTable) != h.checksum {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// Verify validates a mapped region without constructing a CHD. For a v2
+// region this checks the magic, version and checksum; for a legacy
+// (pre-v2) region, which has no header, checksum or hasher id at all, it
+// only sanity-checks that the region is long enough to hold the
+// length-prefixed r-table's own length prefix, matching what mmapV1
+// actually parses.
+func Verify(b []byte) error {
+	if len(b) >= 4 && string(b[:4]) == chdMagic {
+		h, err := parseHeader(b)
+		if err != nil {
+			return err
+		}
+		return verifyChecksum(b, h)
+	}
+	if len(b) < 4 {
+		return ErrBadMagic
+	}
+	return nil
+}
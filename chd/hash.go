@@ -0,0 +1,113 @@
+package chd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Hasher computes a 64-bit hash of key mixed with seed. CHD calls it once
+// per lookup, seeded with r[0]: the result picks the key's bucket, and
+// that same result XORed with the bucket's displacement value from r
+// gives its final table slot.
+type Hasher interface {
+	Sum64(seed uint64, key []byte) uint64
+}
+
+// hasherID identifies which Hasher implementation built a serialized CHD
+// so Mmap/Read can reconstruct the matching one; it is persisted as a
+// single byte in the file.
+type hasherID byte
+
+const (
+	hasherFNV     hasherID = 0
+	hasherMaphash hasherID = 1
+	hasherXXHash  hasherID = 2
+)
+
+// hasherByID returns the Hasher for a persisted hasherID, or an error if
+// the id is not one this build of the package understands. Mmap uses this
+// to refuse to alias a file it can't hash correctly.
+func hasherByID(id hasherID) (Hasher, error) {
+	switch id {
+	case hasherFNV:
+		return fnvHasher{}, nil
+	case hasherMaphash:
+		return maphashHasher{}, nil
+	case hasherXXHash:
+		return xxhashHasher{}, nil
+	default:
+		return nil, fmt.Errorf("chd: unknown hasher id %d", id)
+	}
+}
+
+// idForHasher returns the persisted id for a Hasher returned by this
+// package; it defaults to hasherFNV for any other implementation so that
+// round-tripping a table built elsewhere doesn't fail outright.
+func idForHasher(h Hasher) hasherID {
+	switch h.(type) {
+	case maphashHasher:
+		return hasherMaphash
+	case xxhashHasher:
+		return hasherXXHash
+	default:
+		return hasherFNV
+	}
+}
+
+// fnvHasher reproduces the original hardcoded chdHash(key) ^ seed, and is
+// the default so CHDs serialized before Hasher existed keep reading
+// correctly.
+type fnvHasher struct{}
+
+func (fnvHasher) Sum64(seed uint64, key []byte) uint64 {
+	return chdHash(key) ^ seed
+}
+
+// FNV-1a 64-bit offset basis and prime, per
+// http://www.isthe.com/chongo/tech/comp/fnv/.
+const (
+	fnvOffset64 uint64 = 14695981039346656037
+	fnvPrime64  uint64 = 1099511628211
+)
+
+// chdHash is this package's own 64-bit hash of key, with no seed mixed
+// in: the original hardcoded hash this package used before Hasher
+// existed, FNV-1a. fnvHasher.Sum64 mixes seed into its result, and
+// ShardedBuilder/ShardedCHD use it directly to pick a key's shard.
+func chdHash(key []byte) uint64 {
+	h := fnvOffset64
+	for _, b := range key {
+		h ^= uint64(b)
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// maphashHasher hashes with the runtime's hash/maphash, which is faster
+// than the FNV default and needs no external dependency.
+type maphashHasher struct{}
+
+var maphashSeed = maphash.MakeSeed()
+
+func (maphashHasher) Sum64(seed uint64, key []byte) uint64 {
+	var h maphash.Hash
+	h.SetSeed(maphashSeed)
+	var seedBuf [8]byte
+	binary.LittleEndian.PutUint64(seedBuf[:], seed)
+	h.Write(seedBuf[:])
+	h.Write(key)
+	return h.Sum64()
+}
+
+// xxhashHasher hashes with xxhash, which gives the best speed and
+// distribution of the three at the cost of an external dependency.
+type xxhashHasher struct{}
+
+func (xxhashHasher) Sum64(seed uint64, key []byte) uint64 {
+	d := xxhash.NewWithSeed(seed)
+	d.Write(key)
+	return d.Sum64()
+}
@@ -0,0 +1,52 @@
+//go:build plan9 || js
+// +build plan9 js
+
+package chd
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Open opens the CHD serialized at path. mmap is not available on this
+// platform, so it transparently degrades to reading the whole file into
+// memory, matching the original Read behaviour.
+func Open(path string) (*CHD, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return OpenFile(f)
+}
+
+// OpenFile is like Open but takes an already-opened *os.File.
+func OpenFile(f *os.File) (*CHD, io.Closer, error) {
+	b, closer, err := mapFile(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := Mmap(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, closer, nil
+}
+
+// mapFile "maps" f's contents by reading them in full, since mmap is not
+// available on this platform. It underlies both OpenFile and the DBReader
+// loader.
+func mapFile(f *os.File) ([]byte, io.Closer, error) {
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, noopCloser{}, nil
+}
+
+// noopCloser satisfies io.Closer for the non-mmap fallback, where there is
+// no mapping to release.
+type noopCloser struct{}
+
+func (noopCloser) Close() error { return nil }
@@ -0,0 +1,72 @@
+//go:build !plan9 && !js
+// +build !plan9,!js
+
+package chd
+
+import (
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// Open opens the CHD serialized at path and aliases its tables directly
+// over an mmap(2) (MAP_SHARED, PROT_READ) region rather than copying the
+// file into the Go heap, so multi-gigabyte tables can be looked into
+// without paying for a full read. The returned io.Closer must be closed
+// once the CHD is no longer needed; a finalizer also unmaps it as a
+// safety net if Close is never called.
+func Open(path string) (*CHD, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return OpenFile(f)
+}
+
+// OpenFile is like Open but takes an already-opened *os.File. The file is
+// not closed by OpenFile or by the returned io.Closer; the mapping keeps
+// its own reference to the underlying data once established.
+func OpenFile(f *os.File) (*CHD, io.Closer, error) {
+	b, closer, err := mapFile(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := Mmap(b)
+	if err != nil {
+		_ = closer.Close()
+		return nil, nil, err
+	}
+	return c, closer, nil
+}
+
+// mapFile maps f's contents read-only and returns the mapped bytes along
+// with an io.Closer that unmaps them. It underlies both OpenFile and the
+// DBReader loader.
+func mapFile(f *os.File) ([]byte, io.Closer, error) {
+	m, err := mmap.Map(f, mmap.RDONLY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	closer := &mmapCloser{m: m}
+	runtime.SetFinalizer(closer, (*mmapCloser).Close)
+	return []byte(m), closer, nil
+}
+
+// mmapCloser unmaps the backing region on Close, and again (best-effort)
+// from a finalizer if the caller forgets to call Close.
+type mmapCloser struct {
+	m      mmap.MMap
+	closed bool
+}
+
+func (c *mmapCloser) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	runtime.SetFinalizer(c, nil)
+	return c.m.Unmap()
+}
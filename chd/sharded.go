@@ -0,0 +1,213 @@
+package chd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/alecthomas/mph"
+)
+
+// shardedMagic identifies a file written by ShardedBuilder.Build, as
+// distinct from a single CHD's own v2/legacy formats.
+const shardedMagic = "CHDS"
+
+// shardedHeaderSize is the fixed portion of a sharded file's header,
+// before the per-shard (offset, length) table.
+const shardedHeaderSize = 8
+
+// ShardedBuilder partitions keys across N independently built CHD
+// sub-tables, built concurrently on a worker pool, so construction time
+// and memory scale close to linearly with shard count instead of hitting
+// the wall a single CHD does somewhere past ~10M keys.
+//
+// KNOWN LIMITATION: this type was requested to live in the parent mph
+// package, alongside CHDBuilder, rather than here. It is defined in chd
+// instead because mph is not vendored into this repository snapshot (no
+// go.mod, no local copy), so there is nothing to add it to. Flagging this
+// as a blocker for a follow-up rather than silently treating chd as a
+// substitute home: callers that actually need ShardedBuilder in mph will
+// need that dependency pulled in first. In the meantime it fans out to
+// one mph.CHDBuilder per shard and concatenates their serialized output,
+// the same way DBWriter already delegates to mph.CHDBuilder for the
+// single-table case.
+type ShardedBuilder struct {
+	shards []*mph.CHDBuilder
+}
+
+// NewShardedBuilder creates a ShardedBuilder with n shards.
+func NewShardedBuilder(n int) *ShardedBuilder {
+	shards := make([]*mph.CHDBuilder, n)
+	for i := range shards {
+		shards[i] = mph.Builder()
+	}
+	return &ShardedBuilder{shards: shards}
+}
+
+// Add assigns (key, value) to its shard by hash(key) mod N, the same
+// hash ShardedCHD.Get uses to pick a shard at lookup time.
+func (b *ShardedBuilder) Add(key, value []byte) {
+	b.shardFor(key).Add(key, value)
+}
+
+func (b *ShardedBuilder) shardFor(key []byte) *mph.CHDBuilder {
+	return b.shards[chdHash(key)%uint64(len(b.shards))]
+}
+
+// Build runs CHD construction for every shard concurrently, capped at
+// GOMAXPROCS workers, then writes the result as a single file: a header
+// recording the shard count and each shard's (offset, length) within the
+// data region, followed by the shards' serialized CHDs back to back, each
+// zero-padded to a multiple of 8 bytes so every shard's r-table stays
+// 8-byte aligned.
+func (b *ShardedBuilder) Build(w io.Writer) error {
+	type shardResult struct {
+		buf []byte
+		err error
+	}
+	results := make([]shardResult, len(b.shards))
+
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i, builder := range b.shards {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, builder *mph.CHDBuilder) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			built, err := buildCHD(builder)
+			if err != nil {
+				results[i] = shardResult{err: err}
+				return
+			}
+			var buf bytes.Buffer
+			if err := built.Write(&buf); err != nil {
+				results[i] = shardResult{err: err}
+				return
+			}
+			results[i] = shardResult{buf: buf.Bytes()}
+		}(i, builder)
+	}
+	wg.Wait()
+
+	offsets := make([]uint64, len(results))
+	lengths := make([]uint64, len(results))
+	var offset uint64
+	for i, r := range results {
+		if r.err != nil {
+			return fmt.Errorf("chd: building shard %d: %w", i, r.err)
+		}
+		offsets[i] = offset
+		lengths[i] = uint64(len(r.buf))
+		offset += (uint64(len(r.buf)) + 7) &^ 7
+	}
+
+	if err := writeShardedHeader(w, offsets, lengths); err != nil {
+		return err
+	}
+	var pad [8]byte
+	for _, r := range results {
+		if _, err := w.Write(r.buf); err != nil {
+			return err
+		}
+		if n := len(r.buf) % 8; n != 0 {
+			if _, err := w.Write(pad[:8-n]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeShardedHeader(w io.Writer, offsets, lengths []uint64) error {
+	hdr := make([]byte, shardedHeaderSize+len(offsets)*16)
+	copy(hdr, shardedMagic)
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(len(offsets)))
+	for i := range offsets {
+		off := shardedHeaderSize + i*16
+		binary.LittleEndian.PutUint64(hdr[off:off+8], offsets[i])
+		binary.LittleEndian.PutUint64(hdr[off+8:off+16], lengths[i])
+	}
+	_, err := w.Write(hdr)
+	return err
+}
+
+// ShardedCHD looks up keys across the N CHD sub-tables built by a
+// ShardedBuilder. Get costs one extra chdHash of the key and a modulo over
+// a single CHD's Get to pick the shard, then dispatches into that shard's own mmap-aliased
+// r/indices/keys/values slices -- all still backed by the one underlying
+// file when opened via OpenSharded.
+type ShardedCHD struct {
+	shards []*CHD
+}
+
+// MmapSharded aliases a ShardedCHD over a byte region written by
+// ShardedBuilder.Build (typically mmapped).
+func MmapSharded(b []byte) (*ShardedCHD, error) {
+	if len(b) < shardedHeaderSize || string(b[:4]) != shardedMagic {
+		return nil, fmt.Errorf("chd: not a sharded CHD file")
+	}
+	n := binary.LittleEndian.Uint32(b[4:8])
+	dataStart := shardedHeaderSize + int(n)*16
+	if len(b) < dataStart {
+		return nil, fmt.Errorf("chd: truncated sharded header")
+	}
+
+	dataSize := uint64(len(b) - dataStart)
+	shards := make([]*CHD, n)
+	for i := uint32(0); i < n; i++ {
+		off := shardedHeaderSize + int(i)*16
+		offset := binary.LittleEndian.Uint64(b[off : off+8])
+		length := binary.LittleEndian.Uint64(b[off+8 : off+16])
+		if offset > dataSize || length > dataSize-offset {
+			return nil, fmt.Errorf("chd: shard %d: truncated or corrupt sharded file", i)
+		}
+		c, err := Mmap(b[dataStart+int(offset) : dataStart+int(offset)+int(length)])
+		if err != nil {
+			return nil, fmt.Errorf("chd: shard %d: %w", i, err)
+		}
+		shards[i] = c
+	}
+	return &ShardedCHD{shards: shards}, nil
+}
+
+// OpenSharded opens a file written by ShardedBuilder.Build via the
+// mmap-backed loader used by Open.
+func OpenSharded(path string) (*ShardedCHD, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	b, closer, err := mapFile(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := MmapSharded(b)
+	if err != nil {
+		_ = closer.Close()
+		return nil, nil, err
+	}
+	return s, closer, nil
+}
+
+// Get picks key's shard with the same hash ShardedBuilder.Add used to
+// assign it, then looks it up in that shard alone.
+func (s *ShardedCHD) Get(key []byte) []byte {
+	shard := s.shards[chdHash(key)%uint64(len(s.shards))]
+	return shard.Get(key)
+}
+
+// Len returns the total number of entries across all shards.
+func (s *ShardedCHD) Len() int {
+	n := 0
+	for _, c := range s.shards {
+		n += c.Len()
+	}
+	return n
+}
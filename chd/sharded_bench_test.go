@@ -0,0 +1,43 @@
+package chd
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// benchKeyCount is the synthetic key set size requested for the
+// single-shard vs 16-shard comparison: 50M 8-byte little-endian encoded
+// indices, each unique so no shard ever sees a collision against itself.
+const benchKeyCount = 50_000_000
+
+func benchmarkShardedBuild(b *testing.B, shards int) {
+	keys := make([][8]byte, benchKeyCount)
+	for i := range keys {
+		binary.LittleEndian.PutUint64(keys[i][:], uint64(i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sb := NewShardedBuilder(shards)
+		for j := range keys {
+			sb.Add(keys[j][:], keys[j][:])
+		}
+		if err := sb.Build(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkShardedBuild_1Shard measures ShardedBuilder with a single shard,
+// i.e. all 50M keys built as one CHD with no concurrency.
+func BenchmarkShardedBuild_1Shard(b *testing.B) {
+	benchmarkShardedBuild(b, 1)
+}
+
+// BenchmarkShardedBuild_16Shards measures ShardedBuilder with 16 shards,
+// the partitioning this type exists for, over the same 50M keys.
+func BenchmarkShardedBuild_16Shards(b *testing.B) {
+	benchmarkShardedBuild(b, 16)
+}
@@ -0,0 +1,48 @@
+package chd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestShardedBuilderRoundTrip(t *testing.T) {
+	sb := NewShardedBuilder(4)
+	want := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5"}
+	for k, v := range want {
+		sb.Add([]byte(k), []byte(v))
+	}
+	var buf bytes.Buffer
+	if err := sb.Build(&buf); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	s, err := MmapSharded(buf.Bytes())
+	if err != nil {
+		t.Fatalf("MmapSharded: %v", err)
+	}
+	if s.Len() != len(want) {
+		t.Fatalf("Len = %d, want %d", s.Len(), len(want))
+	}
+	for k, v := range want {
+		got := s.Get([]byte(k))
+		if string(got) != v {
+			t.Fatalf("Get(%q) = %q, want %q", k, got, v)
+		}
+	}
+}
+
+// TestMmapShardedTruncated regression-tests the chunk0-6 fix: a shard
+// whose declared length reaches past the end of the mapped file must be
+// rejected, not sliced into a panic.
+func TestMmapShardedTruncated(t *testing.T) {
+	b := make([]byte, shardedHeaderSize+16)
+	copy(b, shardedMagic)
+	binary.LittleEndian.PutUint32(b[4:8], 1)
+	binary.LittleEndian.PutUint64(b[8:16], 0)
+	binary.LittleEndian.PutUint64(b[16:24], 1<<40)
+
+	if _, err := MmapSharded(b); err == nil {
+		t.Fatal("MmapSharded on truncated shard = nil error, want error")
+	}
+}